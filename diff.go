@@ -0,0 +1,263 @@
+// Copyright 2015 ENDOH takanao.
+// <https://github.com/MiCHiLU/go-gob-http>
+//
+// Copyright 2014 Orchestrate, Inc.
+// <https://github.com/orchestrate-io/dvr>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gobhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+)
+
+// The number of unchanged lines shown around each change in a diff.
+const diffContext = 3
+
+// Returns a unified diff style report of the differences between a recorded
+// response and a live one, covering the status code, headers and body. JSON
+// bodies are pretty printed before being compared so that changes show up
+// line by line. Only the changed hunks are reported, each with a few lines of
+// context. An empty string is returned if the two are the same.
+func DiffResponses(recorded, live *GobResponse) string {
+	a := responseLines(recorded)
+	b := responseLines(live)
+
+	// Work out which lines the two sides have in common.
+	d := &differ{
+		a:       a,
+		b:       b,
+		commonA: make([]bool, len(a)),
+		commonB: make([]bool, len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	// Build the full edit script, recording where each change is.
+	type edit struct {
+		op   byte
+		line string
+		i, j int
+	}
+	edits := make([]edit, 0, len(a)+len(b))
+	changes := []int{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && d.commonA[i] && d.commonB[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && !d.commonA[i]:
+			changes = append(changes, len(edits))
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			changes = append(changes, len(edits))
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	// Group changes that are close together into hunks and write each one
+	// out with its surrounding context.
+	buffer := bytes.Buffer{}
+	buffer.WriteString("--- recorded\n+++ live\n")
+	for c := 0; c < len(changes); {
+		first := changes[c]
+		last := first
+		for c++; c < len(changes) && changes[c]-last <= 2*diffContext+1; c++ {
+			last = changes[c]
+		}
+		from := first - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := last + diffContext + 1
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		lenA, lenB := 0, 0
+		for _, e := range edits[from:to] {
+			if e.op != '+' {
+				lenA++
+			}
+			if e.op != '-' {
+				lenB++
+			}
+		}
+		fmt.Fprintf(&buffer, "@@ -%s +%s @@\n",
+			hunkRange(edits[from].i, lenA), hunkRange(edits[from].j, lenB))
+		for _, e := range edits[from:to] {
+			buffer.WriteByte(e.op)
+			buffer.WriteString(e.line)
+			buffer.WriteByte('\n')
+		}
+	}
+	return buffer.String()
+}
+
+// Formats the start and length of one side of a hunk header. Lines are
+// numbered from one, and an empty range names the line before it.
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// Finds the longest common subsequence of two sets of lines using Myers'
+// linear space divide and conquer algorithm, marking the lines of each side
+// that are part of it.
+type differ struct {
+	a, b             []string
+	commonA, commonB []bool
+}
+
+// Marks the common lines between a[aLo:aHi] and b[bLo:bHi].
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.commonA[aLo], d.commonB[bLo] = true, true
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+		d.commonA[aHi], d.commonB[bHi] = true, true
+	}
+	if aLo == aHi || bLo == bHi {
+		return
+	}
+
+	x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+	for i, j := x, y; i < u; i, j = i+1, j+1 {
+		d.commonA[i], d.commonB[j] = true, true
+	}
+	d.compare(aLo, x, bLo, y)
+	d.compare(u, aHi, v, bHi)
+}
+
+// Returns the start (x, y) and end (u, v) of the middle snake of an optimal
+// edit path between a[aLo:aHi] and b[bLo:bHi], found by running the greedy
+// search forwards from the start and backwards from the end at the same time
+// until the two meet. Only two vectors of size O(N+M) are kept.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n + m + 1) / 2
+	off := limit + 1
+
+	// vf holds the furthest x reached on each diagonal k = x - y going
+	// forwards, vb the same going backwards with both sides reversed.
+	vf := make([]int, 2*limit+3)
+	vb := make([]int, 2*limit+3)
+	for dist := 0; dist <= limit; dist++ {
+		for k := -dist; k <= dist; k += 2 {
+			var fx int
+			if k == -dist || (k != dist && vf[off+k-1] < vf[off+k+1]) {
+				fx = vf[off+k+1]
+			} else {
+				fx = vf[off+k-1] + 1
+			}
+			fy := fx - k
+			sx, sy := fx, fy
+			for fx < n && fy < m && d.a[aLo+fx] == d.b[bLo+fy] {
+				fx++
+				fy++
+			}
+			vf[off+k] = fx
+			c := delta - k
+			if odd && c >= -(dist-1) && c <= dist-1 && fx+vb[off+c] >= n {
+				return aLo + sx, bLo + sy, aLo + fx, bLo + fy
+			}
+		}
+		for c := -dist; c <= dist; c += 2 {
+			var rx int
+			if c == -dist || (c != dist && vb[off+c-1] < vb[off+c+1]) {
+				rx = vb[off+c+1]
+			} else {
+				rx = vb[off+c-1] + 1
+			}
+			ry := rx - c
+			sx, sy := rx, ry
+			for rx < n && ry < m && d.a[aHi-1-rx] == d.b[bHi-1-ry] {
+				rx++
+				ry++
+			}
+			vb[off+c] = rx
+			k := delta - c
+			if !odd && k >= -dist && k <= dist && vf[off+k]+rx >= n {
+				return aHi - rx, bHi - ry, aHi - sx, bHi - sy
+			}
+		}
+	}
+
+	// The two searches always meet before dist passes limit.
+	panic("gobhttp: no middle snake found")
+}
+
+// Renders the parts of a response that DiffResponses compares as a list of
+// lines. Headers are sorted by name so that map ordering does not matter.
+func responseLines(r *GobResponse) []string {
+	if r == nil {
+		return nil
+	}
+
+	lines := []string{fmt.Sprintf("StatusCode: %d", r.StatusCode)}
+	keys := make([]string, 0, len(r.Header))
+	for key := range r.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range r.Header[key] {
+			lines = append(lines, key+": "+value)
+		}
+	}
+
+	// A blank line separates the headers from the body, just like on the
+	// wire.
+	lines = append(lines, "")
+	body := r.Body
+	if isJSON(r.Header.Get("Content-Type")) {
+		pretty := bytes.Buffer{}
+		if err := json.Indent(&pretty, body, "", "  "); err == nil {
+			body = pretty.Bytes()
+		}
+	}
+	if len(body) > 0 {
+		lines = append(lines, strings.Split(string(body), "\n")...)
+	}
+	return lines
+}
+
+// Returns true if the given Content-Type describes a JSON document.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" ||
+		strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,151 @@
+// Copyright 2015 ENDOH takanao.
+// <https://github.com/MiCHiLU/go-gob-http>
+//
+// Copyright 2014 Orchestrate, Inc.
+// <https://github.com/orchestrate-io/dvr>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gobhttp
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/liquidgecka/testlib"
+)
+
+func TestDiffResponses(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	recorded := &GobResponse{
+		StatusCode: 200,
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"X-Version":    []string{"1"},
+		},
+		Body: []byte(`{"id":1,"name":"old"}`),
+	}
+
+	// Identical responses have no diff, even if the JSON is laid out
+	// differently.
+	live := &GobResponse{
+		StatusCode: 200,
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"X-Version":    []string{"1"},
+		},
+		Body: []byte("{\n  \"id\": 1,\n  \"name\": \"old\"\n}"),
+	}
+	T.Equal(DiffResponses(recorded, live), "")
+
+	// Changes to the status, headers and body are all reported.
+	live.StatusCode = 201
+	live.Header.Set("X-Version", "2")
+	live.Body = []byte(`{"id":1,"name":"new"}`)
+	T.Equal(DiffResponses(recorded, live), ""+
+		"--- recorded\n"+
+		"+++ live\n"+
+		"@@ -1,8 +1,8 @@\n"+
+		"-StatusCode: 200\n"+
+		"+StatusCode: 201\n"+
+		" Content-Type: application/json\n"+
+		"-X-Version: 1\n"+
+		"+X-Version: 2\n"+
+		" \n"+
+		" {\n"+
+		"   \"id\": 1,\n"+
+		"-  \"name\": \"old\"\n"+
+		"+  \"name\": \"new\"\n"+
+		" }\n")
+
+	// Non JSON bodies are compared as is.
+	recorded = &GobResponse{StatusCode: 200, Body: []byte("a\nb")}
+	live = &GobResponse{StatusCode: 200, Body: []byte("a\nc")}
+	T.Equal(DiffResponses(recorded, live), ""+
+		"--- recorded\n"+
+		"+++ live\n"+
+		"@@ -1,4 +1,4 @@\n"+
+		" StatusCode: 200\n"+
+		" \n"+
+		" a\n"+
+		"-b\n"+
+		"+c\n")
+}
+
+func TestDiffResponses_LargeBody(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	lines := make([]string, 8000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i)
+	}
+	recorded := &GobResponse{
+		StatusCode: 200,
+		Body:       []byte(strings.Join(lines, "\n")),
+	}
+
+	// A single changed line only reports that line and its context.
+	lines[4000] = "changed"
+	live := &GobResponse{
+		StatusCode: 200,
+		Body:       []byte(strings.Join(lines, "\n")),
+	}
+	report := DiffResponses(recorded, live)
+	T.Equal(report, ""+
+		"--- recorded\n"+
+		"+++ live\n"+
+		"@@ -4000,7 +4000,7 @@\n"+
+		" line 3997\n"+
+		" line 3998\n"+
+		" line 3999\n"+
+		"-line 4000\n"+
+		"+changed\n"+
+		" line 4001\n"+
+		" line 4002\n"+
+		" line 4003\n")
+	if len(report) > 256 {
+		T.Fatalf("Report is %d bytes long.", len(report))
+	}
+
+	// Changes far apart are reported as separate hunks.
+	recorded = &GobResponse{
+		StatusCode: 200,
+		Body:       []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj"),
+	}
+	live = &GobResponse{
+		StatusCode: 200,
+		Body:       []byte("a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk"),
+	}
+	T.Equal(DiffResponses(recorded, live), ""+
+		"--- recorded\n"+
+		"+++ live\n"+
+		"@@ -1,7 +1,7 @@\n"+
+		" StatusCode: 200\n"+
+		" \n"+
+		" a\n"+
+		"-b\n"+
+		"+B\n"+
+		" c\n"+
+		" d\n"+
+		" e\n"+
+		"@@ -10,3 +10,4 @@\n"+
+		" h\n"+
+		" i\n"+
+		" j\n"+
+		"+k\n")
+}
@@ -21,14 +21,17 @@ package gobhttp
 import (
 	"bytes"
 	"crypto/rsa"
-	"crypto/tls"
+	"crypto/x509/pkix"
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"reflect"
+	"syscall"
 )
 
 //
@@ -52,6 +55,8 @@ var encodableTypes map[string]bool = map[string]bool{}
 func init() {
 	// Error return types.
 	registerErrorType(new(gobSafeError))
+	registerErrorType(new(gobWrappedError))
+	registerErrorType(new(gobCauseError))
 	registerErrorType(new(http.ProtocolError))
 	registerErrorType(new(net.AddrError))
 	registerErrorType(new(net.DNSConfigError))
@@ -63,6 +68,12 @@ func init() {
 	registerErrorType(new(net.UnknownNetworkError))
 	registerErrorType(new(url.Error))
 	registerErrorType(new(url.EscapeError))
+	registerErrorType(new(os.SyscallError))
+	registerErrorType(syscall.Errno(0))
+
+	// Sentinel errors that callers compare against directly.
+	RegisterSentinelError(io.EOF)
+	RegisterSentinelError(io.ErrUnexpectedEOF)
 
 	// Other objects that we might end up seeing.
 	gob.Register(new(rsa.PublicKey))
@@ -77,6 +88,12 @@ func init() {
 // If you are using this you must do it via your modules init() otherwise
 // results can be unpredictable.
 func registerErrorType(err error) {
+	// Errors implemented with a value receiver are registered as a value so
+	// that they keep their concrete type when decoded.
+	if reflect.TypeOf(err).Kind() != reflect.Ptr {
+		gob.Register(err)
+	}
+
 	// Walk the given interface all the way down to the raw object.
 	value := reflect.ValueOf(err)
 	for value.Kind() == reflect.Interface || value.Kind() == reflect.Ptr {
@@ -90,6 +107,78 @@ func registerErrorType(err error) {
 	encodableTypes[id] = true
 }
 
+// Identifies a sentinel error by the name of its type and its message.
+type sentinelKey struct {
+	ID      string
+	Message string
+}
+
+// This is the list of registered sentinel errors. When one of these values is
+// encoded it is decoded back to the very same value, rather than an equivalent
+// copy, so that callers comparing against it (err == io.EOF) keep working.
+var sentinelErrors map[sentinelKey]error = map[sentinelKey]error{}
+
+// Registers a sentinel error value, such as io.EOF, so that it decodes back to
+// the exact same value rather than an equivalent copy. If you are using this
+// you must do it via your modules init() otherwise results can be
+// unpredictable.
+func RegisterSentinelError(err error) {
+	sentinelErrors[sentinelKey{errorTypeID(err), err.Error()}] = err
+}
+
+// Returns the package qualified name of the raw type behind the given error.
+func errorTypeID(err error) string {
+	value := reflect.ValueOf(err)
+	for value.Kind() == reflect.Interface || value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	return fmt.Sprintf("%s.%s", value.Type().PkgPath(), value.Type().Name())
+}
+
+// A point in time copy of the known encodable error types and sentinel errors.
+// Tests that call RegisterSentinelError can take a snapshot first and restore
+// it when done so the sentinels they add do not leak into other tests.
+type Registry struct {
+	encodableTypes map[string]bool
+	sentinelErrors map[sentinelKey]error
+}
+
+// Returns a copy of the current set of known encodable error types and
+// sentinel errors which can later be put back in place via Restore().
+func SnapshotRegistry() Registry {
+	return Registry{
+		encodableTypes: copyEncodableTypes(encodableTypes),
+		sentinelErrors: copySentinelErrors(sentinelErrors),
+	}
+}
+
+// Replaces the known encodable error types and sentinel errors with the ones
+// captured in the snapshot. Note that gob.Register has no way of unregistering
+// a type, so any types registered since the snapshot remain known to gob, they
+// are simply no longer treated as encodable by this library.
+func (r Registry) Restore() {
+	encodableTypes = copyEncodableTypes(r.encodableTypes)
+	sentinelErrors = copySentinelErrors(r.sentinelErrors)
+}
+
+// Returns a shallow copy of the given encodable types map.
+func copyEncodableTypes(m map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// Returns a shallow copy of the given sentinel errors map.
+func copySentinelErrors(m map[sentinelKey]error) map[sentinelKey]error {
+	c := make(map[sentinelKey]error, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
 // This type is used to store errors. Since some errors might contain private
 // fields we need to ensure that we can still convert them as best as possible.
 // Specifically this will convert them to a string error.
@@ -106,13 +195,7 @@ func (g *gobError) GobEncode() ([]byte, error) {
 	// If we are encoding a known safe type then we write the types name and
 	// then encode it into the byte stream, otherwise we are forced to convert
 	// it into a gobSafeError type so it can be safely stored.
-	value := reflect.ValueOf(g.Error)
-	for value.Kind() == reflect.Interface || value.Kind() == reflect.Ptr {
-		value = value.Elem()
-	}
-
-	// Figure out the name of this object type.
-	id := fmt.Sprintf("%s.%s", value.Type().PkgPath(), value.Type().Name())
+	id := errorTypeID(g.Error)
 
 	// Make a safe error object for us to encode with.
 	rawError := gobRawError{
@@ -120,16 +203,36 @@ func (g *gobError) GobEncode() ([]byte, error) {
 		ErrorsErrorString: id == "errors.errorString",
 	}
 
+	// If this is a registered sentinel value then record its type so that the
+	// decoder can hand back the sentinel itself. Errors that merely share the
+	// type and message of a sentinel are left alone.
+	sentinel, ok := sentinelErrors[sentinelKey{id, g.Error.Error()}]
+	if ok && sameError(sentinel, g.Error) {
+		rawError.SentinelType = id
+	}
+
 	// If the object that we are encoding is not safe then we need to change
-	// it into one that actually is.
+	// it into one that actually is. Known types that wrap a cause have that
+	// cause converted on its own so that the outer type is kept.
 	if _, ok := encodableTypes[id]; !ok {
-		rawError.Error = gobSafeError(g.Error.Error())
+		rawError.Error = newSafeError(g.Error)
+	} else {
+		rawError.Error = withSafeCause(g.Error)
 	}
 
 	// Encode the safe object and return the byte array.
 	buffer := bytes.Buffer{}
 	encoder := gob.NewEncoder(&buffer)
 	err := encoder.Encode(&rawError)
+
+	// A known type can still carry a field that gob is unable to encode, such
+	// as a net.Conn, in which case we fall back to a safe error.
+	if _, ok := encodableTypes[id]; err != nil && ok {
+		rawError.Error = newSafeError(g.Error)
+		buffer.Reset()
+		encoder = gob.NewEncoder(&buffer)
+		err = encoder.Encode(&rawError)
+	}
 	return buffer.Bytes(), err
 }
 
@@ -146,14 +249,120 @@ func (g *gobError) GobDecode(data []byte) error {
 		return err
 	}
 
+	if rawError.SentinelType != "" && rawError.Error != nil {
+		key := sentinelKey{rawError.SentinelType, rawError.Error.Error()}
+		if sentinel, ok := sentinelErrors[key]; ok {
+			g.Error = sentinel
+			return nil
+		}
+	}
+
 	if rawError.ErrorsErrorString {
 		g.Error = errors.New(rawError.Error.Error())
 	} else {
-		g.Error = rawError.Error
+		g.Error = restoreCause(rawError.Error)
 	}
 	return nil
 }
 
+// Returns a copy of the given known error type with its Err field replaced by
+// a gobCauseError. The cause is often a type that gob can not encode, such as
+// the errors.errorString behind context.Canceled, and converting it on its own
+// means the outer error keeps its type and fields rather than being flattened.
+func withSafeCause(err error) error {
+	switch e := err.(type) {
+	case *url.Error:
+		if e.Err != nil {
+			c := *e
+			c.Err = &gobCauseError{Cause: gobError{Error: e.Err}}
+			return &c
+		}
+	case *net.OpError:
+		if e.Err != nil {
+			c := *e
+			c.Err = &gobCauseError{Cause: gobError{Error: e.Err}}
+			return &c
+		}
+	case *os.SyscallError:
+		if e.Err != nil {
+			c := *e
+			c.Err = &gobCauseError{Cause: gobError{Error: e.Err}}
+			return &c
+		}
+	}
+	return err
+}
+
+// Reverses withSafeCause by putting the decoded cause back into the Err field
+// of the given error.
+func restoreCause(err error) error {
+	switch e := err.(type) {
+	case *url.Error:
+		if c, ok := e.Err.(*gobCauseError); ok {
+			e.Err = c.Cause.Error
+		}
+	case *net.OpError:
+		if c, ok := e.Err.(*gobCauseError); ok {
+			e.Err = c.Cause.Error
+		}
+	case *os.SyscallError:
+		if c, ok := e.Err.(*gobCauseError); ok {
+			e.Err = c.Cause.Error
+		}
+	}
+	return err
+}
+
+// This is a safe type stored in the Err field of known error types while they
+// are encoded. It only ever exists in the byte stream since GobDecode swaps it
+// back out for the decoded cause.
+type gobCauseError struct {
+	Cause gobError
+}
+
+// Error() for gobCauseError
+func (g *gobCauseError) Error() string {
+	if g.Cause.Error == nil {
+		return ""
+	}
+	return g.Cause.Error.Error()
+}
+
+// Converts the given error into one that can always be gob encoded. Errors
+// that wrap another error are converted into a gobWrappedError so that the
+// cause is preserved, everything else is flattened into a gobSafeError.
+func newSafeError(err error) error {
+	if wrapper, ok := err.(interface {
+		Unwrap() error
+	}); ok {
+		if cause := wrapper.Unwrap(); cause != nil {
+			return &gobWrappedError{
+				Message: err.Error(),
+				Cause:   gobError{Error: cause},
+			}
+		}
+	}
+	return gobSafeError(err.Error())
+}
+
+// This is a safe type used in place of errors that wrap another error. The
+// cause is stored as a gobError so it goes through the same conversion as the
+// outer error, which keeps the whole chain intact for errors.Unwrap.
+type gobWrappedError struct {
+	Message string
+	Cause   gobError
+}
+
+// Error() for gobWrappedError
+func (g *gobWrappedError) Error() string {
+	return g.Message
+}
+
+// Unwrap() for gobWrappedError
+func (g *gobWrappedError) Unwrap() error {
+	return g.Cause.Error
+}
+
 // This is the object type that gobError will use when encoding and decoding.
 // The assumption here is that the Error field in this object will be populated
 // only with safe error types.
@@ -163,6 +372,87 @@ type gobRawError struct {
 	// This is set to true if the error was initially a 'errors.errorString'
 	// so we know that we can convert it back in the decoding process.
 	ErrorsErrorString bool
+
+	// This is set to the type name of the error if it was a registered
+	// sentinel so the decoder can return the sentinel value itself.
+	SentinelType string
+}
+
+//
+// Header helpers
+//
+
+// Returns the number of bytes the given header occupies when written out by
+// http.Header.Write, which is the same layout used on the wire.
+func headerSize(h http.Header) int {
+	buffer := bytes.Buffer{}
+	h.Write(&buffer)
+	return buffer.Len()
+}
+
+// The value that redacted header values are replaced with.
+const RedactedValue = "REDACTED"
+
+// Returns a copy of the given header with every value of the named headers
+// replaced by RedactedValue. Both the names and the keys of the header are
+// canonicalized so that "authorization" and "Authorization" always match,
+// even when the header map was populated directly rather than via Set. The
+// given header is never modified.
+func redactHeader(h http.Header, names []string) http.Header {
+	if h == nil {
+		return nil
+	}
+	redact := make(map[string]bool, len(names))
+	for _, name := range names {
+		redact[http.CanonicalHeaderKey(name)] = true
+	}
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+		if redact[http.CanonicalHeaderKey(k)] {
+			for i := range c[k] {
+				c[k][i] = RedactedValue
+			}
+		}
+	}
+	return c
+}
+
+//
+// Body helpers
+//
+
+// Runs the body through scrub, ensuring that the result is never nil.
+func scrubBody(
+	req *http.Request, body []byte,
+	scrub func(req *http.Request, body []byte) []byte,
+) []byte {
+	if body = scrub(req, body); body == nil {
+		body = []byte{}
+	}
+	return body
+}
+
+//
+// TLS wrapper
+//
+
+// This is a gob encodable subset of tls.ConnectionState. The full structure
+// carries the entire peer certificate chain, which can be very large and holds
+// values that gob is unable to encode, so only the fields that are typically
+// inspected are kept.
+type GobTLSState struct {
+	Version            uint16
+	HandshakeComplete  bool
+	DidResume          bool
+	CipherSuite        uint16
+	NegotiatedProtocol string
+	ServerName         string
+
+	// The subject and DNS names of the peer's leaf certificate. LeafSubject
+	// is nil if the peer did not present a certificate.
+	LeafSubject  *pkix.Name
+	LeafDNSNames []string
 }
 
 //
@@ -187,7 +477,10 @@ type GobRequest struct {
 	Trailer          http.Header
 	RemoteAddr       string
 	RequestURI       string
-	TLS              *tls.ConnectionState
+	TLS              *GobTLSState
+
+	// The size in bytes of Header when written in wire format.
+	HeaderSize int
 
 	// The request body and err returned when reading it.
 	Body  []byte
@@ -202,7 +495,9 @@ func NewGobRequest(req *http.Request) *GobRequest {
 
 	r := new(GobRequest)
 	r.Method = req.Method
-	r.URL = req.URL.String()
+	if req.URL != nil {
+		r.URL = req.URL.String()
+	}
 	r.Proto = req.Proto
 	r.ProtoMajor = req.ProtoMajor
 	r.ProtoMinor = req.ProtoMinor
@@ -216,11 +511,30 @@ func NewGobRequest(req *http.Request) *GobRequest {
 	r.Trailer = req.Trailer
 	r.RemoteAddr = req.RemoteAddr
 	r.RequestURI = req.RequestURI
+	r.HeaderSize = headerSize(req.Header)
 	newGobRequestVS(req, r)
 
 	return r
 }
 
+// Replaces the values of the named headers with RedactedValue so that secrets
+// such as Authorization or Cookie are not persisted. The header is copied
+// first, so the http.Request this object was created from keeps the real
+// values.
+func (r *GobRequest) RedactHeaders(names []string) {
+	r.Header = redactHeader(r.Header, names)
+}
+
+// Passes the stored body through the given scrub function so that secrets
+// embedded in it can be removed before it is persisted. The request is handed
+// to scrub so that scrubbing can be keyed off of the URL. A nil return value
+// is stored as an empty body.
+func (r *GobRequest) ScrubBody(
+	req *http.Request, scrub func(req *http.Request, body []byte) []byte,
+) {
+	r.Body = scrubBody(req, r.Body, scrub)
+}
+
 //
 // Response wrapper
 //
@@ -238,7 +552,10 @@ type GobResponse struct {
 	TransferEncoding []string
 	Close            bool
 	Trailer          http.Header
-	TLS              *tls.ConnectionState
+	TLS              *GobTLSState
+
+	// The size in bytes of Header when written in wire format.
+	HeaderSize int
 
 	// The response body and err returned when reading it.
 	Body  []byte
@@ -262,7 +579,25 @@ func NewGobResponse(resp *http.Response) *GobResponse {
 	r.TransferEncoding = resp.TransferEncoding
 	r.Close = resp.Close
 	r.Trailer = resp.Trailer
+	r.HeaderSize = headerSize(resp.Header)
 	newGobResponseVS(resp, r)
 
 	return r
 }
+
+// Replaces the values of the named headers with RedactedValue so that secrets
+// such as Set-Cookie are not persisted. The header is copied first, so the
+// http.Response this object was created from keeps the real values.
+func (r *GobResponse) RedactHeaders(names []string) {
+	r.Header = redactHeader(r.Header, names)
+}
+
+// Passes the stored body through the given scrub function so that secrets
+// embedded in it can be removed before it is persisted. The request that
+// produced this response is handed to scrub so that scrubbing can be keyed off
+// of the URL. A nil return value is stored as an empty body.
+func (r *GobResponse) ScrubBody(
+	req *http.Request, scrub func(req *http.Request, body []byte) []byte,
+) {
+	r.Body = scrubBody(req, r.Body, scrub)
+}
@@ -19,11 +19,16 @@
 package gobhttp
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/gob"
 	"errors"
+	"io"
 	"net/http"
+	"os"
 	"reflect"
+	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/liquidgecka/testlib"
@@ -36,6 +41,20 @@ func (p customError) Error() string {
 	return string(p)
 }
 
+// Gob encodes in and then decodes the result into out.
+func roundTrip(T *testlib.T, in, out interface{}) {
+	buffer := &bytes.Buffer{}
+	T.ExpectSuccess(gob.NewEncoder(buffer).Encode(in))
+	T.ExpectSuccess(gob.NewDecoder(buffer).Decode(out))
+}
+
+// Encodes the given error via a gobError and returns the decoded version.
+func roundTripError(T *testlib.T, err error) error {
+	g := new(gobError)
+	roundTrip(T, &gobError{Error: err}, g)
+	return g.Error
+}
+
 func TestGobError_GobEncode(t *testing.T) {
 	T := testlib.NewT(t)
 	defer T.Finish()
@@ -114,6 +133,16 @@ func TestGobError_GobDecode(t *testing.T) {
 	// a simple bogus data check.
 	g := &gobError{}
 	T.ExpectError(g.GobDecode([]byte{0, 1, 2, 3}))
+
+	// A raw error without an error value decodes to nil, even when it
+	// claims to be a sentinel.
+	for _, raw := range []gobRawError{{}, {SentinelType: "x"}} {
+		buffer := &bytes.Buffer{}
+		T.ExpectSuccess(gob.NewEncoder(buffer).Encode(&raw))
+		g = &gobError{Error: errors.New("Unexpected")}
+		T.ExpectSuccess(g.GobDecode(buffer.Bytes()))
+		T.Equal(g.Error, nil)
+	}
 }
 
 func TestSimpleCoverage(t *testing.T) {
@@ -121,4 +150,244 @@ func TestSimpleCoverage(t *testing.T) {
 	defer T.Finish()
 	T.Equal(NewGobRequest(nil), nil)
 	T.Equal(NewGobResponse(nil), nil)
+
+	// A hand built request without a URL must not panic.
+	r := NewGobRequest(&http.Request{Method: "GET"})
+	T.Equal(r.Method, "GET")
+	T.Equal(r.URL, "")
+}
+
+func TestNewGobRequest_RequestLine(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	// Each of these request-target forms must survive encoding exactly as
+	// they were read off the wire.
+	lines := []string{
+		"GET /path?q=1 HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"GET http://example.com/path HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"OPTIONS * HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+	}
+	for _, line := range lines {
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(line)))
+		T.ExpectSuccess(err)
+
+		r := new(GobRequest)
+		roundTrip(T, NewGobRequest(req), r)
+		T.Equal(r.Method, req.Method)
+		T.Equal(r.RequestURI, req.RequestURI)
+		T.Equal(r.Proto, req.Proto)
+	}
+}
+
+func TestHeaderSize(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	header := http.Header{
+		"Content-Type": []string{"text/plain"},
+		"X-Multi":      []string{"a", "b"},
+	}
+	// "Content-Type: text/plain\r\n" + "X-Multi: a\r\n" + "X-Multi: b\r\n"
+	expected := 26 + 12 + 12
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	T.ExpectSuccess(err)
+	req.Header = header
+	T.Equal(NewGobRequest(req).HeaderSize, expected)
+
+	resp := &http.Response{Header: header}
+	T.Equal(NewGobResponse(resp).HeaderSize, expected)
+
+	// A missing header has no size.
+	T.Equal(NewGobResponse(&http.Response{}).HeaderSize, 0)
+}
+
+// An error implementation used to test registry snapshots.
+type registryError string
+
+func (p registryError) Error() string {
+	return string(p)
+}
+
+func TestRegistry_Restore(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	registry := SnapshotRegistry()
+
+	// Once registered the type survives encoding.
+	registerErrorType(new(registryError))
+	err := roundTripError(T, registryError("Expected"))
+	if _, ok := err.(*registryError); !ok {
+		T.Fatalf("Registered error was not preserved.")
+	}
+
+	// Restoring the snapshot makes the type unknown again.
+	registry.Restore()
+	err = roundTripError(T, registryError("Expected"))
+	if _, ok := err.(*gobSafeError); !ok {
+		T.Fatalf("Restored registry still knows registryError.")
+	}
+
+	// Built in types are still known after a restore, and restoring twice
+	// is safe.
+	registry.Restore()
+	err = roundTripError(T, &http.ProtocolError{ErrorString: "Expected"})
+	if _, ok := err.(*http.ProtocolError); !ok {
+		T.Fatalf("err is not a *http.ProtocolError, its a %T", err)
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	T.ExpectSuccess(err)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Add("Cookie", "a=1")
+	req.Header.Add("Cookie", "b=2")
+	req.Header.Set("Accept", "text/plain")
+
+	r := NewGobRequest(req)
+	r.RedactHeaders([]string{"authorization", "COOKIE"})
+	T.Equal(r.Header, http.Header{
+		"Authorization": []string{RedactedValue},
+		"Cookie":        []string{RedactedValue, RedactedValue},
+		"Accept":        []string{"text/plain"},
+	})
+
+	// The original request must keep the real values.
+	T.Equal(req.Header.Get("Authorization"), "Bearer secret")
+	T.Equal(req.Header["Cookie"], []string{"a=1", "b=2"})
+
+	resp := &http.Response{Header: http.Header{
+		"Set-Cookie": []string{"session=secret"},
+	}}
+	r2 := NewGobResponse(resp)
+	r2.RedactHeaders([]string{"set-cookie"})
+	T.Equal(r2.Header.Get("Set-Cookie"), RedactedValue)
+	T.Equal(resp.Header.Get("Set-Cookie"), "session=secret")
+
+	// Keys that were not stored in canonical form are redacted too.
+	req.Header["authorization"] = []string{"Bearer secret"}
+	r4 := NewGobRequest(req)
+	r4.RedactHeaders([]string{"Authorization"})
+	T.Equal(r4.Header["authorization"], []string{RedactedValue})
+	T.Equal(r4.Header["Authorization"], []string{RedactedValue})
+	T.Equal(req.Header["authorization"], []string{"Bearer secret"})
+
+	// A nil header stays nil.
+	r3 := NewGobResponse(&http.Response{})
+	r3.RedactHeaders([]string{"Set-Cookie"})
+	if r3.Header != nil {
+		T.Fatalf("r3.Header should be nil, got %v", r3.Header)
+	}
+}
+
+func TestScrubBody(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	req, err := http.NewRequest("POST", "http://example.com/login", nil)
+	T.ExpectSuccess(err)
+
+	// Only scrub bodies sent to /login.
+	scrub := func(req *http.Request, body []byte) []byte {
+		if req.URL.Path != "/login" {
+			return body
+		}
+		return bytes.Replace(body, []byte("hunter2"), []byte("XXX"), -1)
+	}
+
+	r := NewGobRequest(req)
+	r.Body = []byte(`{"password":"hunter2"}`)
+	r.ScrubBody(req, scrub)
+	T.Equal(string(r.Body), `{"password":"XXX"}`)
+
+	r2 := NewGobResponse(&http.Response{})
+	r2.Body = []byte(`{"token":"hunter2"}`)
+	r2.ScrubBody(req, scrub)
+	T.Equal(string(r2.Body), `{"token":"XXX"}`)
+
+	// A nil result is stored as an empty body.
+	r2.ScrubBody(req, func(*http.Request, []byte) []byte { return nil })
+	T.Equal(r2.Body, []byte{})
+}
+
+func TestGobError_StdlibErrors(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	// *os.SyscallError keeps both its type and the wrapped errno. A raw
+	// Errno is used as the named constants are not defined on every OS.
+	errno := syscall.Errno(104)
+	err := roundTripError(T, os.NewSyscallError("read", errno))
+	if serr, ok := err.(*os.SyscallError); !ok {
+		T.Fatalf("err is not a *os.SyscallError, its a %T", err)
+	} else {
+		T.Equal(serr.Syscall, "read")
+		T.Equal(serr.Err, errno)
+	}
+
+	// io.EOF and io.ErrUnexpectedEOF keep their concrete type.
+	for _, e := range []error{io.EOF, io.ErrUnexpectedEOF} {
+		err = roundTripError(T, e)
+		T.Equal(reflect.TypeOf(err), reflect.TypeOf(e))
+		T.Equal(err.Error(), e.Error())
+	}
+}
+
+// An error implementation whose values can not be compared.
+type sliceError []string
+
+func (p sliceError) Error() string {
+	return strings.Join(p, " ")
+}
+
+func TestRegisterSentinelError(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	// The built in sentinels come back as themselves.
+	if err := roundTripError(T, io.EOF); err != io.EOF {
+		T.Fatalf("Expected io.EOF, got %#v", err)
+	}
+	if err := roundTripError(T, io.ErrUnexpectedEOF); err != io.ErrUnexpectedEOF {
+		T.Fatalf("Expected io.ErrUnexpectedEOF, got %#v", err)
+	}
+
+	// An error with the same message is not the sentinel.
+	if err := roundTripError(T, errors.New("EOF")); err == io.EOF {
+		T.Fatalf("errors.New(\"EOF\") decoded as io.EOF")
+	}
+
+	// Custom sentinels, including ones with an unencodable type, work once
+	// registered.
+	registry := SnapshotRegistry()
+	defer registry.Restore()
+	errCustom := errors.New("Expected")
+	errUnknown := customError("Sentinel")
+	RegisterSentinelError(errCustom)
+	RegisterSentinelError(errUnknown)
+	if err := roundTripError(T, errCustom); err != errCustom {
+		T.Fatalf("Expected errCustom, got %#v", err)
+	}
+	if err := roundTripError(T, errUnknown); err != errUnknown {
+		T.Fatalf("Expected errUnknown, got %#v", err)
+	}
+
+	// Sentinels with a type that can not be compared never match, rather
+	// than panicking.
+	RegisterSentinelError(sliceError{"Sentinel"})
+	err := roundTripError(T, sliceError{"Sentinel"})
+	T.Equal(err.Error(), "Sentinel")
+
+	// After restoring the registry the custom sentinel is forgotten.
+	registry.Restore()
+	if err := roundTripError(T, errCustom); err == errCustom {
+		T.Fatalf("errCustom survived a registry restore")
+	}
 }
@@ -21,6 +21,7 @@
 package gobhttp
 
 import (
+	"crypto/tls"
 	"net/http"
 )
 
@@ -34,3 +35,9 @@ func newGobRequestVS(req *http.Request, r *GobRequest) {
 // This call does nothing since golang's prior to 1.3 do not have TLS fields.
 func newGobResponseVS(resp *http.Response, r *GobResponse) {
 }
+
+// golang's prior to 1.3 never record TLS state so there is nothing to convert
+// back.
+func (s *GobTLSState) ConnectionState() *tls.ConnectionState {
+	return nil
+}
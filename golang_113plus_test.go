@@ -0,0 +1,150 @@
+// Copyright 2015 ENDOH takanao.
+// <https://github.com/MiCHiLU/go-gob-http>
+//
+// Copyright 2014 Orchestrate, Inc.
+// <https://github.com/orchestrate-io/dvr>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.13
+
+package gobhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/liquidgecka/testlib"
+)
+
+func TestGobError_WrappedErrors(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	// A chain of fmt.Errorf wrappers keeps every link.
+	inner := fmt.Errorf("inner: %w", customError("Unexpected"))
+	err := roundTripError(T, fmt.Errorf("outer: %w", inner))
+	T.Equal(err.Error(), "outer: inner: Unexpected")
+	cause := errors.Unwrap(err)
+	if cause == nil {
+		T.Fatalf("errors.Unwrap returned nil")
+	}
+	T.Equal(cause.Error(), "inner: Unexpected")
+	cause = errors.Unwrap(cause)
+	if _, ok := cause.(*gobSafeError); !ok {
+		T.Fatalf("cause is not a *gobSafeError, its a %T", cause)
+	}
+	T.Equal(errors.Unwrap(cause), nil)
+
+	// Sentinels at the end of a chain are still found by errors.Is.
+	err = roundTripError(T, fmt.Errorf("reading: %w", io.EOF))
+	T.Equal(errors.Is(err, io.EOF), true)
+
+	// A known type whose cause can not be encoded directly keeps both its
+	// own type and fields and its cause.
+	uerr := &url.Error{
+		Op:  "Get",
+		URL: "http://example.com/",
+		Err: context.Canceled,
+	}
+	err = roundTripError(T, uerr)
+	T.Equal(err.Error(), uerr.Error())
+	T.Equal(errors.Is(err, context.Canceled), true)
+	T.Equal(errors.As(err, new(*url.Error)), true)
+	T.Equal(err.(*url.Error).Op, "Get")
+	T.Equal(err.(*url.Error).URL, "http://example.com/")
+	if uerr.Err != context.Canceled {
+		T.Fatalf("Encoding modified the original error: %#v", uerr.Err)
+	}
+
+	// The same holds through several layers of known types.
+	errno := syscall.Errno(104)
+	operr := &net.OpError{
+		Op:  "read",
+		Net: "tcp",
+		Err: os.NewSyscallError("read", errno),
+	}
+	err = roundTripError(T, &url.Error{Op: "Get", URL: "/", Err: operr})
+	T.Equal(err.Error(), (&url.Error{Op: "Get", URL: "/", Err: operr}).Error())
+	T.Equal(errors.As(err, new(*url.Error)), true)
+	T.Equal(errors.As(err, new(*net.OpError)), true)
+	T.Equal(errors.As(err, new(*os.SyscallError)), true)
+	T.Equal(errors.Is(err, errno), true)
+
+	// Errors that do not wrap anything are flattened as before.
+	err = roundTripError(T, fmt.Errorf("plain"))
+	T.Equal(errors.Unwrap(err), nil)
+}
+
+// RecordHeaderError.Conn only showed up in golang 1.12 so this test lives
+// here rather than alongside the other standard library errors.
+func TestGobError_RecordHeaderError(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	// tls.RecordHeaderError is returned by value so it must decode as one.
+	rerr := tls.RecordHeaderError{Msg: "Expected", RecordHeader: [5]byte{1, 2}}
+	err := roundTripError(T, rerr)
+	T.Equal(err, rerr)
+
+	// Once it carries a connection it can no longer be encoded, so it falls
+	// back to a gobSafeError rather than failing.
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	rerr.Conn = c1
+	err = roundTripError(T, rerr)
+	if _, ok := err.(*gobSafeError); !ok {
+		T.Fatalf("err is not a *gobSafeError, its a %T", err)
+	}
+	T.Equal(err.Error(), rerr.Error())
+}
+
+func TestGobResponse_CanceledRequest(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Make a request whose context is already canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	T.ExpectSuccess(err)
+	resp, err := http.DefaultClient.Do(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	T.Equal(errors.Is(err, context.Canceled), true)
+
+	// Store the error the same way a recording would and read it back.
+	r := new(GobResponse)
+	roundTrip(T, &GobResponse{Error: gobError{Error: err}}, r)
+	T.Equal(r.Error.Error.Error(), err.Error())
+	T.Equal(errors.Is(r.Error.Error, context.Canceled), true)
+	uerr := new(*url.Error)
+	T.Equal(errors.As(r.Error.Error, uerr), true)
+	T.Equal((*uerr).URL, server.URL)
+}
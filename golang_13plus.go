@@ -21,6 +21,8 @@
 package gobhttp
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"net/http"
 )
 
@@ -30,11 +32,65 @@ import (
 // This call wraps copying the TLS value since it only showed up in golang
 // 1.3 and higher.
 func newGobRequestVS(req *http.Request, r *GobRequest) {
-	r.TLS = req.TLS
+	r.TLS = newGobTLSState(req.TLS)
 }
 
 // This call wraps copying the TLS value since it only showed up in golang
 // 1.3 and higher.
 func newGobResponseVS(resp *http.Response, r *GobResponse) {
-	r.TLS = resp.TLS
+	r.TLS = newGobTLSState(resp.TLS)
+}
+
+// This takes a ConnectionState object and returns a gob compatible
+// GobTLSState object.
+func newGobTLSState(cs *tls.ConnectionState) *GobTLSState {
+	if cs == nil {
+		return nil
+	}
+
+	s := new(GobTLSState)
+	s.Version = cs.Version
+	s.HandshakeComplete = cs.HandshakeComplete
+	s.DidResume = cs.DidResume
+	s.CipherSuite = cs.CipherSuite
+	s.NegotiatedProtocol = cs.NegotiatedProtocol
+	s.ServerName = cs.ServerName
+	if len(cs.PeerCertificates) > 0 {
+		leaf := cs.PeerCertificates[0]
+		subject := leaf.Subject
+		s.LeafSubject = &subject
+		s.LeafDNSNames = leaf.DNSNames
+
+		// The parsed attribute lists hold interface values which gob can
+		// not encode, the named fields of the subject hold the same data.
+		s.LeafSubject.Names = nil
+		clearExtraNames(s.LeafSubject)
+	}
+
+	return s
+}
+
+// Converts the stored state back into a tls.ConnectionState. If a leaf
+// certificate was recorded then PeerCertificates will hold a single
+// certificate populated with only its subject and DNS names.
+func (s *GobTLSState) ConnectionState() *tls.ConnectionState {
+	if s == nil {
+		return nil
+	}
+
+	cs := new(tls.ConnectionState)
+	cs.Version = s.Version
+	cs.HandshakeComplete = s.HandshakeComplete
+	cs.DidResume = s.DidResume
+	cs.CipherSuite = s.CipherSuite
+	cs.NegotiatedProtocol = s.NegotiatedProtocol
+	cs.ServerName = s.ServerName
+	if s.LeafSubject != nil {
+		cs.PeerCertificates = []*x509.Certificate{{
+			Subject:  *s.LeafSubject,
+			DNSNames: s.LeafDNSNames,
+		}}
+	}
+
+	return cs
 }
@@ -0,0 +1,109 @@
+// Copyright 2015 ENDOH takanao.
+// <https://github.com/MiCHiLU/go-gob-http>
+//
+// Copyright 2014 Orchestrate, Inc.
+// <https://github.com/orchestrate-io/dvr>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.3
+
+package gobhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/http"
+	"testing"
+
+	"github.com/liquidgecka/testlib"
+)
+
+func TestNewGobResponse_TLSDidResume(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	resp := &http.Response{
+		TLS: &tls.ConnectionState{
+			Version:           tls.VersionTLS12,
+			HandshakeComplete: true,
+			DidResume:         true,
+		},
+	}
+
+	// Encode the response and make sure the resumption state came back.
+	r := new(GobResponse)
+	roundTrip(T, NewGobResponse(resp), r)
+	if r.TLS == nil {
+		T.Fatalf("r.TLS was not decoded.")
+	}
+	T.Equal(r.TLS.DidResume, true)
+	T.Equal(r.TLS.Version, uint16(tls.VersionTLS12))
+}
+
+func TestNewGobResponse_TLSState(t *testing.T) {
+	T := testlib.NewT(t)
+	defer T.Finish()
+
+	// The leaf certificate carries fields gob can not encode, such as the
+	// parsed attribute values and the public key.
+	subject := pkix.Name{
+		CommonName:   "example.com",
+		Organization: []string{"Example"},
+		Names: []pkix.AttributeTypeAndValue{
+			{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "example.com"},
+		},
+	}
+	leaf := &x509.Certificate{
+		Subject:   subject,
+		DNSNames:  []string{"example.com", "www.example.com"},
+		PublicKey: struct{ unexported int }{},
+	}
+	resp := &http.Response{
+		TLS: &tls.ConnectionState{
+			Version:            tls.VersionTLS12,
+			HandshakeComplete:  true,
+			CipherSuite:        tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			NegotiatedProtocol: "h2",
+			ServerName:         "example.com",
+			PeerCertificates:   []*x509.Certificate{leaf, leaf},
+		},
+	}
+
+	// Encode the response and convert the decoded state back.
+	r := new(GobResponse)
+	roundTrip(T, NewGobResponse(resp), r)
+	cs := r.TLS.ConnectionState()
+	T.Equal(cs.Version, resp.TLS.Version)
+	T.Equal(cs.HandshakeComplete, true)
+	T.Equal(cs.CipherSuite, resp.TLS.CipherSuite)
+	T.Equal(cs.NegotiatedProtocol, "h2")
+	T.Equal(cs.ServerName, "example.com")
+	T.Equal(len(cs.PeerCertificates), 1)
+	T.Equal(cs.PeerCertificates[0].Subject.CommonName, "example.com")
+	T.Equal(cs.PeerCertificates[0].Subject.Organization, []string{"Example"})
+	T.Equal(cs.PeerCertificates[0].DNSNames, leaf.DNSNames)
+
+	// The original certificate must not have been modified.
+	T.Equal(len(leaf.Subject.Names), 1)
+
+	// Without a certificate there are no peer certificates, and without TLS
+	// there is no state at all.
+	resp.TLS.PeerCertificates = nil
+	T.Equal(len(NewGobResponse(resp).TLS.ConnectionState().PeerCertificates), 0)
+	if NewGobResponse(&http.Response{}).TLS.ConnectionState() != nil {
+		T.Fatalf("Expected a nil ConnectionState.")
+	}
+}